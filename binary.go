@@ -0,0 +1,224 @@
+package oplogc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// The binary encoding of operations is the protobuf wire format of the following
+// messages, so operations can be forwarded and decoded by any protobuf
+// implementation:
+//
+//	message Operation {
+//	  string id = 1;
+//	  string event = 2;
+//	  OperationData data = 3;
+//	  string source = 4;
+//	  uint64 seq = 5;
+//	  uint64 conn_gen = 6;
+//	}
+//
+//	message OperationData {
+//	  string id = 1;
+//	  string type = 2;
+//	  string ref = 3;
+//	  int64 timestamp = 4; // Unix time in nanoseconds
+//	  repeated string parents = 5;
+//	  map<string, bytes> extra = 6;
+//	}
+
+// ErrInvalidBinary is returned by UnmarshalBinary when the data is not a valid
+// binary encoded operation.
+var ErrInvalidBinary = errors.New("invalid binary operation")
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// MarshalBinary encodes the operation in the protobuf wire format. Only the
+// exported fields are encoded, the decoded operation can't be acked.
+func (o Operation) MarshalBinary() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, o.ID)
+	b = appendString(b, 2, o.Event)
+	if o.Data != nil {
+		data, _ := o.Data.MarshalBinary()
+		b = appendBytes(b, 3, data)
+	}
+	b = appendString(b, 4, o.Source)
+	if o.Seq != 0 {
+		b = appendTag(b, 5, wireVarint)
+		b = appendVarint(b, o.Seq)
+	}
+	if o.ConnGen != 0 {
+		b = appendTag(b, 6, wireVarint)
+		b = appendVarint(b, o.ConnGen)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes an operation encoded by MarshalBinary.
+func (o *Operation) UnmarshalBinary(b []byte) error {
+	*o = Operation{}
+	return readFields(b, func(field int, wire int, v uint64, raw []byte) error {
+		switch {
+		case field == 1 && wire == wireBytes:
+			o.ID = string(raw)
+		case field == 2 && wire == wireBytes:
+			o.Event = string(raw)
+		case field == 3 && wire == wireBytes:
+			o.Data = &OperationData{}
+			return o.Data.UnmarshalBinary(raw)
+		case field == 4 && wire == wireBytes:
+			o.Source = string(raw)
+		case field == 5 && wire == wireVarint:
+			o.Seq = v
+		case field == 6 && wire == wireVarint:
+			o.ConnGen = v
+		}
+		return nil
+	})
+}
+
+// MarshalBinary encodes the operation data in the protobuf wire format.
+func (d OperationData) MarshalBinary() ([]byte, error) {
+	var b []byte
+	b = appendString(b, 1, d.ID)
+	b = appendString(b, 2, d.Type)
+	b = appendString(b, 3, d.Ref)
+	if !d.Timestamp.IsZero() {
+		b = appendTag(b, 4, wireVarint)
+		b = appendVarint(b, uint64(d.Timestamp.UnixNano()))
+	}
+	for _, p := range d.Parents {
+		b = appendTag(b, 5, wireBytes)
+		b = appendVarint(b, uint64(len(p)))
+		b = append(b, p...)
+	}
+	for k, v := range d.Extra {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendBytes(entry, 2, v)
+		b = appendBytes(b, 6, entry)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes operation data encoded by MarshalBinary.
+func (d *OperationData) UnmarshalBinary(b []byte) error {
+	*d = OperationData{}
+	return readFields(b, func(field int, wire int, v uint64, raw []byte) error {
+		switch {
+		case field == 1 && wire == wireBytes:
+			d.ID = string(raw)
+		case field == 2 && wire == wireBytes:
+			d.Type = string(raw)
+		case field == 3 && wire == wireBytes:
+			d.Ref = string(raw)
+		case field == 4 && wire == wireVarint:
+			d.Timestamp = time.Unix(0, int64(v)).UTC()
+		case field == 5 && wire == wireBytes:
+			d.Parents = append(d.Parents, string(raw))
+		case field == 6 && wire == wireBytes:
+			var key string
+			var value json.RawMessage
+			err := readFields(raw, func(field int, wire int, v uint64, raw []byte) error {
+				if wire == wireBytes {
+					switch field {
+					case 1:
+						key = string(raw)
+					case 2:
+						value = append([]byte{}, raw...)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if d.Extra == nil {
+				d.Extra = map[string]json.RawMessage{}
+			}
+			d.Extra[key] = value
+		}
+		return nil
+	})
+}
+
+// appendVarint appends a protobuf varint
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// appendTag appends a protobuf field tag
+func appendTag(b []byte, field int, wire int) []byte {
+	return appendVarint(b, uint64(field)<<3|uint64(wire))
+}
+
+// appendBytes appends a length delimited protobuf field
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendString appends a string protobuf field, omitted if empty
+func appendString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return appendBytes(b, field, []byte(v))
+}
+
+// readFields reads the protobuf fields of a message, calling fn with the field
+// number, the wire type and either the varint value or the raw bytes of the
+// field. Unknown wire types are rejected.
+func readFields(b []byte, fn func(field int, wire int, v uint64, raw []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrInvalidBinary
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+		var v uint64
+		var raw []byte
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return ErrInvalidBinary
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return ErrInvalidBinary
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return ErrInvalidBinary
+			}
+			raw, b = b[n:n+int(l)], b[n+int(l):]
+		case wireFixed32:
+			if len(b) < 4 {
+				return ErrInvalidBinary
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return ErrInvalidBinary
+		}
+		if err := fn(field, wire, v, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
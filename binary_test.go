@@ -0,0 +1,41 @@
+package oplogc
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOperationBinary(t *testing.T) {
+	op := Operation{
+		ID:      "54a4a5f0e4b0a3b1c2d3e4f5",
+		Event:   "update",
+		Source:  "videos",
+		Seq:     42,
+		ConnGen: 3,
+		Data: &OperationData{
+			ID:        "x1",
+			Type:      "video",
+			Ref:       "http://api/video/x1",
+			Timestamp: time.Date(2015, 1, 1, 0, 0, 0, 123, time.UTC),
+			Parents:   []string{"user/u1", "playlist/a/b"},
+			Extra:     map[string]json.RawMessage{"owner": json.RawMessage(`"u1"`)},
+		},
+	}
+	b, err := op.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := Operation{}
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary() error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, op) {
+		t.Errorf("got %+v, want %+v", decoded, op)
+	}
+
+	if err := decoded.UnmarshalBinary(b[:len(b)-3]); err != ErrInvalidBinary {
+		t.Errorf("UnmarshalBinary() of truncated data error = %v, want %v", err, ErrInvalidBinary)
+	}
+}
@@ -0,0 +1,39 @@
+package oplogc
+
+import "time"
+
+// breaker is a circuit breaker opening after a number of consecutive failures
+// within a time window.
+type breaker struct {
+	// threshold is the number of consecutive failures opening the breaker,
+	// the breaker is disabled if 0
+	threshold int
+	// window is the duration in which the failures must happen, no limit if 0
+	window time.Duration
+	// failures is the number of consecutive failures in the current window
+	failures int
+	// since is the time of the first failure of the current window
+	since time.Time
+}
+
+// failure records a failure and returns true if the breaker must open.
+func (b *breaker) failure(now time.Time) bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	if b.failures == 0 || (b.window > 0 && now.Sub(b.since) > b.window) {
+		b.failures = 0
+		b.since = now
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.failures = 0
+		return true
+	}
+	return false
+}
+
+// success resets the consecutive failures.
+func (b *breaker) success() {
+	b.failures = 0
+}
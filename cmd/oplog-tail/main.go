@@ -8,6 +8,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -23,6 +24,7 @@ var (
 	types            = flag.String("types", "", "Comma seperated list of types to filter on.")
 	parents          = flag.String("parents", "", "Comma seperated list of parents type/id to filter on.")
 	allowReplication = flag.Bool("allow-replication", false, "Try to do a full replication (ignored if -state-file is not provided).")
+	tailN            = flag.Int("n", 0, "Output the last n operations before going live (ignored if the state file exists).")
 )
 
 func main() {
@@ -39,15 +41,19 @@ func main() {
 	url := flag.Arg(0)
 
 	f := oplogc.Filter{
-		Types:   strings.Split(*types, ","),
-		Parents: strings.Split(*parents, ","),
+		Types:   splitList(*types),
+		Parents: splitList(*parents),
 	}
-	c := oplogc.Subscribe(url, oplogc.Options{
+	c, err := oplogc.Subscribe(url, oplogc.Options{
 		StateFile:        *stateFile,
 		Password:         *password,
 		AllowReplication: *allowReplication,
+		TailN:            *tailN,
 		Filter:           f,
 	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	ops, errs, done := c.Start()
 	for {
@@ -69,14 +75,14 @@ func main() {
 			}
 			op.Done()
 		case err := <-errs:
-			switch err {
-			case oplogc.ErrAccessDenied, oplogc.ErrWritingState:
+			switch {
+			case err == oplogc.ErrAccessDenied, err == oplogc.ErrWritingState:
 				c.Stop()
 				log.Fatal(err)
-			case oplogc.ErrResumeFailed:
+			case errors.Is(err, oplogc.ErrResumeFailed), err == oplogc.ErrFilterChanged:
 				if *stateFile != "" {
-					log.Print("Resume failed, forcing full replication")
-					c.SetLastID("0")
+					log.Printf("%v: forcing full replication", err)
+					c.FullReplication()
 				} else {
 					log.Print(err)
 				}
@@ -88,3 +94,15 @@ func main() {
 		}
 	}
 }
+
+// splitList splits a comma separated list, ignoring empty elements. An empty list
+// returns nil so no filter is applied.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,1098 @@
+package oplogc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dailymotion/oplogc"
+	"github.com/dailymotion/oplogc/oplogtest"
+)
+
+func testOperation(id, event string) oplogc.Operation {
+	return oplogc.Operation{
+		ID:    id,
+		Event: event,
+		Data:  &oplogc.OperationData{ID: "obj" + id, Type: "video", Timestamp: time.Now()},
+	}
+}
+
+// nextOperation returns the next operation received, failing the test on
+// error or timeout
+func nextOperation(t *testing.T, ops <-chan oplogc.Operation, errs <-chan error) oplogc.Operation {
+	t.Helper()
+	select {
+	case op := <-ops:
+		return op
+	case err := <-errs:
+		t.Fatalf("unexpected error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for an operation")
+	}
+	return oplogc.Operation{}
+}
+
+func TestConsumerDelivery(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"), testOperation("2", "update"))
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	s.Push(testOperation("3", "delete"))
+	for i, want := range []string{"1", "2", "3"} {
+		op := nextOperation(t, ops, errs)
+		if op.ID != want || op.Data.ID != "obj"+want || op.Seq != uint64(i+1) {
+			t.Errorf("got operation %+v, want id %s and seq %d", op, want, i+1)
+		}
+		op.Done()
+	}
+}
+
+func TestConsumerResume(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"), testOperation("2", "update"))
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	for i := 0; i < 2; i++ {
+		op := nextOperation(t, ops, errs)
+		c.AckSync(op)
+	}
+	s.Disconnect()
+	s.Push(testOperation("3", "insert"))
+
+	// The connection loss is reported before reconnecting
+	select {
+	case err := <-errs:
+		if err != oplogc.ErrConnectionClosed {
+			t.Fatalf("got error %v, want %v", err, oplogc.ErrConnectionClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the connection loss")
+	}
+	if op := nextOperation(t, ops, errs); op.ID != "3" || op.ConnGen != 2 {
+		t.Errorf("resumed at operation %s from connection %d, want 3 from connection 2", op.ID, op.ConnGen)
+	}
+	if ids := s.LastEventIDs(); len(ids) != 2 || ids[0] != "" || ids[1] != "2" {
+		t.Errorf("got Last-Event-ID headers %q, want [\"\" \"2\"]", ids)
+	}
+}
+
+func TestConsumerAccessDenied(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"))
+	s.Password = "secret"
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Ping(context.Background()); err != oplogc.ErrAccessDenied {
+		t.Errorf("Ping() = %v, want %v", err, oplogc.ErrAccessDenied)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	select {
+	case op := <-ops:
+		t.Fatalf("unexpected operation %+v", op)
+	case err := <-errs:
+		if err != oplogc.ErrAccessDenied {
+			t.Errorf("got error %v, want %v", err, oplogc.ErrAccessDenied)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the error")
+	}
+}
+
+func TestConsumerStopOnLive(t *testing.T) {
+	acks := map[string]func(c *oplogc.Consumer, op oplogc.Operation){
+		"Done":    func(c *oplogc.Consumer, op oplogc.Operation) { op.Done() },
+		"AckSync": func(c *oplogc.Consumer, op oplogc.Operation) { c.AckSync(op) },
+	}
+	for name, ack := range acks {
+		t.Run(name, func(t *testing.T) {
+			s := oplogtest.NewServer(
+				oplogc.Operation{ID: "1", Event: "reset"},
+				testOperation("2", "insert"),
+				oplogc.Operation{ID: "3", Event: "live"},
+				testOperation("4", "insert"),
+			)
+			defer s.Close()
+
+			c, err := oplogc.Subscribe(s.URL, oplogc.Options{StopOnLive: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ops, errs, done := c.Start()
+
+			for _, want := range []string{"1", "2", "3"} {
+				op := nextOperation(t, ops, errs)
+				if op.ID != want {
+					t.Fatalf("got operation %s, want %s", op.ID, want)
+				}
+				ack(c, op)
+			}
+			select {
+			case <-done:
+			case op := <-ops:
+				t.Fatalf("unexpected operation %s after live", op.ID)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for the loop to end")
+			}
+			if id := c.LastID(); id != "3" {
+				t.Errorf("LastID() = %q, want %q", id, "3")
+			}
+			if r, ok := c.Replication(); !ok || r.Ended.IsZero() || len(r.Counts) != 1 || r.Counts["insert"] != 1 {
+				t.Errorf("Replication() = %+v, %v, want an ended replication with 1 insert", r, ok)
+			}
+		})
+	}
+}
+
+func TestConsumerRange(t *testing.T) {
+	s := oplogtest.NewServer()
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		s.Push(testOperation(id, "insert"))
+	}
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{FromID: "1", ToID: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	if m := c.StartMode(); m != oplogc.StartModeResume {
+		t.Errorf("StartMode() = %s, want %s", m, oplogc.StartModeResume)
+	}
+
+	for _, want := range []string{"2", "3"} {
+		op := nextOperation(t, ops, errs)
+		if op.ID != want {
+			t.Fatalf("got operation %s, want %s", op.ID, want)
+		}
+		op.Done()
+	}
+	select {
+	case <-done:
+	case op := <-ops:
+		t.Fatalf("unexpected operation %s after the range", op.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the loop to end")
+	}
+	if ids := s.LastEventIDs(); len(ids) != 1 || ids[0] != "1" {
+		t.Errorf("got Last-Event-ID headers %q, want [\"1\"]", ids)
+	}
+}
+
+func TestConsumerRangeEndingOnSkippedOperation(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"), testOperation("2", "insert"), testOperation("3", "update"))
+	defer s.Close()
+
+	store := &memoryStore{}
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{
+		StateStore: store,
+		FromID:     "1",
+		ToID:       "3",
+		Ruleset:    oplogc.NewRuleset(oplogc.Rule{Event: "insert"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+
+	op := nextOperation(t, ops, errs)
+	if op.ID != "2" {
+		t.Fatalf("got operation %s, want 2", op.ID)
+	}
+	op.Done()
+	select {
+	case <-done:
+	case op := <-ops:
+		t.Fatalf("unexpected operation %s", op.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the loop to end on the skipped last operation")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.state != nil {
+		t.Errorf("range replay saved the state %s", store.state)
+	}
+}
+
+func TestConsumerRateLimited(t *testing.T) {
+	var mu sync.Mutex
+	requests := []time.Time{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, time.Now())
+		n := len(requests)
+		mu.Unlock()
+		if n == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "id: 1\nevent: insert\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	select {
+	case err := <-errs:
+		var rerr *oplogc.RateLimitedError
+		if !errors.As(err, &rerr) || rerr.RetryAfter != 2*time.Second {
+			t.Fatalf("got error %v, want rate limited for 2s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the rate limited error")
+	}
+	op := nextOperation(t, ops, errs)
+	if op.ID != "1" {
+		t.Fatalf("got operation %s, want 1", op.ID)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// The backoff would have reconnected after 1s
+	if d := requests[1].Sub(requests[0]); d < 2*time.Second {
+		t.Errorf("reconnected after %s, want at least the 2s requested", d)
+	}
+}
+
+func TestConsumerTryStart(t *testing.T) {
+	s := oplogtest.NewServer()
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, done, err := c.TryStart()
+	if err != nil {
+		t.Fatalf("TryStart() error: %v", err)
+	}
+	if _, _, _, err := c.TryStart(); err != oplogc.ErrAlreadyStarted {
+		t.Errorf("second TryStart() error = %v, want %v", err, oplogc.ErrAlreadyStarted)
+	}
+	c.Stop()
+	<-done
+}
+
+// memoryStore is a StateStore keeping the state in memory
+type memoryStore struct {
+	mu     sync.Mutex
+	state  []byte
+	closed bool
+}
+
+func (s *memoryStore) Load() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, nil
+}
+
+func (s *memoryStore) Save(state []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestConsumerClose(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"))
+	defer s.Close()
+
+	store := &memoryStore{}
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{StateStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, _ := c.Start()
+	op := nextOperation(t, ops, errs)
+	op.Done()
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if string(store.state) != "1" || !store.closed {
+		t.Errorf("got state %q and closed %v, want state \"1\" flushed and store closed", store.state, store.closed)
+	}
+}
+
+func TestConsumerStopWithPendingDelivery(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"))
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, done := c.Start()
+
+	// Let the operation be pending delivery with nobody reading ops
+	for deadline := time.Now().Add(5 * time.Second); c.Status().InFlight == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the operation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked by an operation pending delivery")
+	}
+}
+
+func TestConsumerRetry(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"))
+	defer s.Close()
+
+	deadLetters := make(chan oplogc.Operation, 1)
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{
+		MaxRetries:   1,
+		RetryBackoff: 10 * time.Millisecond,
+		OnDeadLetter: func(op oplogc.Operation, err error) {
+			deadLetters <- op
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	op := nextOperation(t, ops, errs)
+	op.Retry()
+	op = nextOperation(t, ops, errs)
+	if op.ID != "1" || op.Retries() != 1 {
+		t.Fatalf("got operation %s retried %d times, want 1 retried once", op.ID, op.Retries())
+	}
+	op.Retry()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, oplogc.ErrRetriesExhausted) {
+			t.Fatalf("got error %v, want %v", err, oplogc.ErrRetriesExhausted)
+		}
+	case op := <-ops:
+		t.Fatalf("unexpected operation %s retried %d times", op.ID, op.Retries())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the retries to be exhausted")
+	}
+	select {
+	case op := <-deadLetters:
+		if op.ID != "1" {
+			t.Errorf("got dead letter %s, want 1", op.ID)
+		}
+	default:
+		t.Error("OnDeadLetter not called")
+	}
+	for deadline := time.Now().Add(5 * time.Second); c.LastID() != "1"; {
+		if time.Now().After(deadline) {
+			t.Fatal("operation not acked once its retries exhausted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsumerRetryStalledOperation(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"))
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{
+		StalledAckTimeout: 10 * time.Millisecond,
+		RetryBackoff:      10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	delivered := nextOperation(t, ops, errs)
+	var stalled *oplogc.StalledAckError
+	select {
+	case err := <-errs:
+		if !errors.As(err, &stalled) {
+			t.Fatalf("got error %v, want a StalledAckError", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the stalled operation to be reported")
+	}
+	// Retrying the reported copy must not panic, and acts on the operation
+	// delivered, which can't be acked anymore
+	stalled.Operation.Retry()
+	delivered.Done()
+	op := nextOperation(t, ops, errs)
+	if op.ID != "1" || op.Retries() != 1 {
+		t.Fatalf("got operation %s retried %d times, want 1 retried once", op.ID, op.Retries())
+	}
+	if c.LastID() != "" {
+		t.Errorf("LastID() = %q before the retried operation is acked", c.LastID())
+	}
+	op.Done()
+	for deadline := time.Now().Add(5 * time.Second); c.LastID() != "1"; {
+		if time.Now().After(deadline) {
+			t.Fatal("retried operation not acked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsumerFromStream(t *testing.T) {
+	first := "id: 1\nevent: insert\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n"
+	second := "id: 2\nevent: update\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n"
+	reconnects := make(chan string, 1)
+	c, err := oplogc.NewConsumerFromStream(ioutil.NopCloser(strings.NewReader(first)), oplogc.Options{
+		Reconnect: func(lastID string) (io.ReadCloser, error) {
+			reconnects <- lastID
+			return ioutil.NopCloser(strings.NewReader(second)), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	op := nextOperation(t, ops, errs)
+	if op.ID != "1" {
+		t.Fatalf("got operation %s, want 1", op.ID)
+	}
+	c.AckSync(op)
+	// The end of the first stream is reported before reconnecting
+	<-errs
+	if op := nextOperation(t, ops, errs); op.ID != "2" {
+		t.Fatalf("got operation %s, want 2", op.ID)
+	}
+	if lastID := <-reconnects; lastID != "1" {
+		t.Errorf("Reconnect called with last id %q, want %q", lastID, "1")
+	}
+}
+
+func TestConsumerSkipInvalidEvents(t *testing.T) {
+	stream := "id: 1\nevent: insert\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n" +
+		"id: 2\nevent: insert\ndata: {\"id\":\n\n" +
+		"id: 3\nevent: update\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n"
+	c, err := oplogc.NewConsumerFromStream(ioutil.NopCloser(strings.NewReader(stream)), oplogc.Options{
+		SkipInvalidEvents: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	op := nextOperation(t, ops, errs)
+	if op.ID != "1" {
+		t.Fatalf("got operation %s, want 1", op.ID)
+	}
+	op.Done()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, oplogc.ErrInvalidEvent) {
+			t.Fatalf("got error %v, want %v", err, oplogc.ErrInvalidEvent)
+		}
+	case op := <-ops:
+		t.Fatalf("got operation %s before the invalid event warning", op.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the invalid event warning")
+	}
+	op = nextOperation(t, ops, errs)
+	if op.ID != "3" {
+		t.Fatalf("got operation %s, want 3", op.ID)
+	}
+	op.Done()
+	// The invalid event is acked along with the others
+	for deadline := time.Now().Add(5 * time.Second); c.LastID() != "3"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("LastID() = %q, want 3", c.LastID())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsumerWait(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"))
+	s.Password = "secret"
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{Password: "wrong", CollectErrors: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	time.AfterFunc(200*time.Millisecond, c.Stop)
+
+	err = c.Wait()
+	var collected *oplogc.CollectedErrors
+	if !errors.As(err, &collected) || len(collected.Errors) != 1 || collected.Errors[0] != oplogc.ErrAccessDenied {
+		t.Errorf("Wait() = %v, want the access denied error collected", err)
+	}
+}
+
+func TestConsumerDuplicateReset(t *testing.T) {
+	s := oplogtest.NewServer(
+		oplogc.Operation{ID: "1", Event: "reset"},
+		testOperation("2", "insert"),
+		oplogc.Operation{ID: "3", Event: "reset"},
+		testOperation("4", "insert"),
+	)
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{OnDuplicateReset: oplogc.DuplicateResetFail})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	for _, want := range []string{"1", "2"} {
+		op := nextOperation(t, ops, errs)
+		if op.ID != want {
+			t.Fatalf("got operation %s, want %s", op.ID, want)
+		}
+		op.Done()
+	}
+	select {
+	case err := <-errs:
+		if err != oplogc.ErrDuplicateReset {
+			t.Fatalf("got error %v, want %v", err, oplogc.ErrDuplicateReset)
+		}
+	case op := <-ops:
+		t.Fatalf("unexpected operation %s", op.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the duplicate reset")
+	}
+	if op := nextOperation(t, ops, errs); op.ID != "4" {
+		t.Errorf("got operation %s, want 4", op.ID)
+	}
+}
+
+func TestConsumerFlush(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"))
+	defer s.Close()
+
+	store := &memoryStore{}
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{StateStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	c.AckSync(nextOperation(t, ops, errs))
+	if err := c.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if string(store.state) != "1" {
+		t.Errorf("got state %q, want \"1\"", store.state)
+	}
+}
+
+func TestConsumerStreamJSON(t *testing.T) {
+	s := oplogtest.NewServer(
+		oplogc.Operation{ID: "1", Event: "reset"},
+		oplogc.Operation{ID: "2", Event: "insert", Data: &oplogc.OperationData{ID: "a", Type: "video"}},
+		oplogc.Operation{ID: "3", Event: "live"},
+	)
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{StopOnLive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := c.StreamJSON(&b); err != nil {
+		t.Fatalf("StreamJSON() error: %v", err)
+	}
+	want := `{"id":"1","event":"reset"}
+{"id":"2","event":"insert","data":{"id":"a","type":"video","timestamp":"0001-01-01T00:00:00Z","parents":null}}
+{"id":"3","event":"live"}
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+// failingStore is a StateStore failing to load the state a number of times
+type failingStore struct {
+	memoryStore
+	failures int
+}
+
+func (s *failingStore) Load() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return nil, errors.New("volume unavailable")
+	}
+	return s.state, nil
+}
+
+// failingSaveStore is a StateStore failing to save the state a number of times
+type failingSaveStore struct {
+	memoryStore
+	failures int
+	saves    int
+}
+
+func (s *failingSaveStore) Save(state []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saves++
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("volume unavailable")
+	}
+	s.state = state
+	return nil
+}
+
+func TestConsumerStateErrorRetry(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"))
+	defer s.Close()
+
+	store := &failingSaveStore{failures: 2}
+	saved := make(chan string, 2)
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{
+		StateStore:       store,
+		StateErrorPolicy: oplogc.StateErrorRetry,
+		OnStateSaved: func(id string) {
+			saved <- id
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	op := nextOperation(t, ops, errs)
+	op.Done()
+	// Failing at 1s and 3s, the save succeeds at 7s
+	select {
+	case err := <-errs:
+		if err != oplogc.ErrWritingState {
+			t.Fatalf("got error %v, want %v", err, oplogc.ErrWritingState)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the save failure")
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected error %v while retrying", err)
+	case id := <-saved:
+		if id != "1" {
+			t.Fatalf("saved %q, want 1", id)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the save to be retried")
+	}
+	store.mu.Lock()
+	saves := store.saves
+	store.mu.Unlock()
+	if saves != 3 {
+		t.Errorf("Save called %d times, want 3", saves)
+	}
+
+	// Recovered, the next save happens at the normal interval
+	s.Push(testOperation("2", "insert"))
+	op = nextOperation(t, ops, errs)
+	op.Done()
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected error %v after recovering", err)
+	case id := <-saved:
+		if id != "2" {
+			t.Fatalf("saved %q, want 2", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for the save after recovering")
+	}
+}
+
+func TestConsumerStateLoadRetries(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"))
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{StateStore: &failingStore{failures: 1}, StateLoadRetries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	if op := nextOperation(t, ops, errs); op.ID != "1" {
+		t.Errorf("got operation %s, want 1", op.ID)
+	}
+	c.Stop()
+	<-done
+
+	// The load error is reported once the retries are exhausted
+	c, err = oplogc.Subscribe(s.URL, oplogc.Options{StateStore: &failingStore{failures: 2}, StateLoadRetries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, errs, done = c.Start()
+	select {
+	case err := <-errs:
+		if err == nil || err.Error() != "volume unavailable" {
+			t.Errorf("got error %v, want the load error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the load error")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the loop to end")
+	}
+}
+
+func TestConsumerStopFlushesState(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"), testOperation("2", "insert"))
+	defer s.Close()
+
+	store := &memoryStore{}
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{StateStore: store, MinStateWriteInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	if _, _, err := c.AckSync(nextOperation(t, ops, errs)); err != nil {
+		t.Fatalf("AckSync() error: %v", err)
+	}
+	// Acked while the next write is delayed for an hour
+	op := nextOperation(t, ops, errs)
+	op.Done()
+	c.Stop()
+	<-done
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if string(store.state) != "2" {
+		t.Errorf("got state %q, want \"2\" flushed on stop", store.state)
+	}
+}
+
+func TestConsumerRetriesExhaustedDoesNotBlockAcks(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"), testOperation("2", "insert"))
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{MaxRetries: 1, RetryBackoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	op := nextOperation(t, ops, errs)
+	op.Retry()
+	var retried, second oplogc.Operation
+	for retried.ID == "" || second.ID == "" {
+		op := nextOperation(t, ops, errs)
+		if op.ID == "1" {
+			retried = op
+		} else {
+			second = op
+		}
+	}
+	retried.Retry()
+
+	// Acking while nobody reads errs must not wait for the error to be read
+	acked := make(chan struct{})
+	go func() {
+		second.Done()
+		close(acked)
+	}()
+	select {
+	case <-acked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ack blocked by the unread retries exhausted error")
+	}
+	if err := <-errs; !errors.Is(err, oplogc.ErrRetriesExhausted) {
+		t.Errorf("got error %v, want %v", err, oplogc.ErrRetriesExhausted)
+	}
+}
+
+func TestConsumerStopAfterLoopEnded(t *testing.T) {
+	s := oplogtest.NewServer(testOperation("1", "insert"))
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A Stop before the first Start ends its loop right away
+	c.Stop()
+	_, _, done := c.Start()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop stopped before Start not ended")
+	}
+	// No loop is running, this Stop must not end the next loop
+	c.Stop()
+
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+	if op := nextOperation(t, ops, errs); op.ID != "1" {
+		t.Errorf("got operation %s, want 1", op.ID)
+	}
+}
+
+func TestConsumerTailWithMaxInFlightBytes(t *testing.T) {
+	s := oplogtest.NewServer(oplogc.Operation{ID: "1", Event: "reset"})
+	for _, id := range []string{"2", "3", "4", "5", "6"} {
+		s.Push(testOperation(id, "insert"))
+	}
+	s.Push(oplogc.Operation{ID: "7", Event: "live"})
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{TailN: 2, MaxInFlightBytes: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	for _, want := range []string{"5", "6", "7"} {
+		op := nextOperation(t, ops, errs)
+		if op.ID != want {
+			t.Fatalf("got operation %s, want %s", op.ID, want)
+		}
+		op.Done()
+	}
+}
+
+func TestConsumerTailReconnect(t *testing.T) {
+	s := oplogtest.NewServer(oplogc.Operation{ID: "1", Event: "reset"})
+	for _, id := range []string{"2", "3", "4", "5", "6"} {
+		s.Push(testOperation(id, "insert"))
+	}
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{TailN: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	// The operations older than the last 2 are acked without being delivered
+	for deadline := time.Now().Add(5 * time.Second); c.LastID() != "4"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("LastID() = %q, want 4", c.LastID())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Resuming from 4, the held operations are sent again
+	s.Disconnect()
+	select {
+	case err := <-errs:
+		if err != oplogc.ErrConnectionClosed {
+			t.Fatalf("got error %v, want %v", err, oplogc.ErrConnectionClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the disconnection")
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(s.LastEventIDs()) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the consumer to reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Push(oplogc.Operation{ID: "7", Event: "live"})
+
+	received := []oplogc.Operation{}
+	for _, want := range []string{"5", "6", "7"} {
+		op := nextOperation(t, ops, errs)
+		if op.ID != want {
+			t.Fatalf("got operation %s, want %s", op.ID, want)
+		}
+		received = append(received, op)
+	}
+	if id := c.LastID(); id != "4" {
+		t.Errorf("LastID() = %q before the tail is acked, want 4", id)
+	}
+	for _, op := range received {
+		op.Done()
+	}
+	select {
+	case op := <-ops:
+		t.Fatalf("unexpected operation %s", op.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestConsumerStaleWithoutOperation(t *testing.T) {
+	s := oplogtest.NewServer()
+	defer s.Close()
+
+	c, err := oplogc.Subscribe(s.URL, oplogc.Options{MaxEventStaleness: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	select {
+	case err := <-errs:
+		if err != oplogc.ErrStaleStream {
+			t.Fatalf("got error %v, want %v", err, oplogc.ErrStaleStream)
+		}
+	case op := <-ops:
+		t.Fatalf("unexpected operation %s", op.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a connection receiving nothing to be stale")
+	}
+}
+
+func TestConsumerBreaker(t *testing.T) {
+	t.Run("InitialFailure", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer s.Close()
+
+		c, err := oplogc.Subscribe(s.URL, oplogc.Options{BreakerThreshold: 1, BreakerCooldown: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, errs, done := c.Start()
+		defer func() {
+			c.Stop()
+			<-done
+		}()
+
+		// The failed initial connection opens the breaker right away
+		<-errs
+		if err := <-errs; err != oplogc.ErrCircuitOpen {
+			t.Errorf("got error %v, want %v", err, oplogc.ErrCircuitOpen)
+		}
+	})
+
+	t.Run("RecoveredResume", func(t *testing.T) {
+		s := oplogtest.NewServer(testOperation("1", "insert"))
+		defer s.Close()
+
+		c, err := oplogc.Subscribe(s.URL, oplogc.Options{
+			FromID:           "unknown",
+			OnResumeFailed:   oplogc.ResumeFailedFullReplication,
+			BreakerThreshold: 1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops, errs, done := c.Start()
+		defer func() {
+			c.Stop()
+			<-done
+		}()
+
+		for _, want := range []error{oplogc.ErrCircuitOpen, oplogc.ErrResumeRecovered} {
+			select {
+			case err := <-errs:
+				if err != want {
+					t.Fatalf("got error %v, want %v", err, want)
+				}
+			case op := <-ops:
+				t.Fatalf("got operation %s, want error %v", op.ID, want)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timeout waiting for %v", want)
+			}
+		}
+		if op := nextOperation(t, ops, errs); op.ID != "1" {
+			t.Errorf("got operation %s, want 1", op.ID)
+		}
+	})
+}
@@ -5,7 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ErrIncompleteEvent is returned when the decoder only recieved a partial event
@@ -19,64 +23,285 @@ var ErrConnectionClosed = errors.New("connection closed")
 
 type decoder struct {
 	*bufio.Reader
+	// onRaw is called with the raw bytes of each event before it is parsed
+	onRaw func([]byte)
+	// onComment is called for each comment line received, like heartbeats
+	onComment func()
+	// fields is the set of data fields to decode, all fields are decoded if nil
+	fields map[string]bool
+	// partial is the type the fields are decoded into, built on first use
+	partial *partialData
+	// version is the schema version of the data, see Options.SchemaVersion
+	version int
+	// skipLF is true when the last line ended with a "\r" which may be followed
+	// by a "\n" part of the same line terminator
+	skipLF bool
 }
 
+// dataFields are the fields of the first schema version of the operation data
+var dataFields = []string{"id", "type", "ref", "timestamp", "parents"}
+
+// eolReplacer normalizes the line terminators to "\n"
+var eolReplacer = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
 func newDecoder(r io.Reader) *decoder {
-	return &decoder{bufio.NewReader(r)}
+	return &decoder{Reader: bufio.NewReader(r)}
 }
 
 // next reads the next operation from a SSE stream or block until one comes in.
 func (d *decoder) next(op *Operation) (err error) {
 	// Reset non reusable fields
+	op.ID = ""
 	op.Event = ""
 	op.Data = nil
+	op.rawSize = 0
 
-	var line string
-	started := false
+	raw, err := d.readEvent()
+	op.rawSize = len(raw)
+	if err == nil && d.onRaw != nil {
+		d.onRaw(raw)
+	}
 
-	for {
-		if line, err = d.ReadString('\n'); err != nil {
-			err = ErrConnectionClosed
-			break
-		}
-		if line == "\n" {
-			if started {
-				// Message is complete, send it
-				break
-			}
-			continue
-		}
-		line = strings.TrimSuffix(line, "\n")
-		if strings.HasPrefix(line, ":") {
-			// Comment, ignore
+	data := ""
+	for _, line := range strings.Split(eolReplacer.Replace(string(raw)), "\n") {
+		if line == "" || strings.HasPrefix(line, ":") {
+			// Blank line or comment, ignore
 			continue
 		}
-		started = true
-		sections := strings.SplitN(line, ":", 2)
-		field, value := sections[0], ""
-		if len(sections) == 2 {
-			value = strings.TrimPrefix(sections[1], " ")
-		}
+		field, value := parseField(line)
 		switch field {
 		case "id":
+			// An id field with no value resets the id
 			op.ID = value
 		case "event":
 			op.Event = value
 		case "data":
 			// The oplog does never return data on serveral lines
-			if err = json.Unmarshal([]byte(value), &op.Data); err != nil {
-				err = ErrInvalidEvent
-				break
-			}
+			data = value
+		}
+	}
+
+	// Empty data leaves Data unset
+	if err == nil && data != "" {
+		var derr error
+		if op.Kind().IsControl() {
+			derr = decodeControlData(data, op)
+		} else {
+			derr = d.decodeData(data, op)
+		}
+		if derr != nil {
+			err = ErrInvalidEvent
 		}
 	}
 
 	if err == nil && op.Event == "" {
 		err = ErrIncompleteEvent
 	}
-	if !op.validate() {
+	if err == nil && !op.validate() {
 		err = ErrInvalidEvent
 	}
 
 	return
 }
+
+// decodeControlData decodes the JSON data of a control event, like the expected
+// count sent with the live event. As control events don't refer to an object, all
+// the fields are kept in Data.Extra.
+func decodeControlData(value string, op *Operation) error {
+	extra := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(value), &extra); err != nil {
+		return err
+	}
+	op.Data = &OperationData{Extra: extra}
+	return nil
+}
+
+// decodeData decodes the JSON data of an event into the operation. If a set of
+// fields is defined, only those fields are decoded, the others being skipped.
+func (d *decoder) decodeData(value string, op *Operation) error {
+	if d.fields == nil {
+		if err := json.Unmarshal([]byte(value), &op.Data); err != nil {
+			return err
+		}
+		if d.version < 2 || op.Data == nil {
+			return nil
+		}
+		extra := map[string]json.RawMessage{}
+		if err := json.Unmarshal([]byte(value), &extra); err != nil {
+			return err
+		}
+		for _, key := range dataFields {
+			delete(extra, key)
+		}
+		if len(extra) > 0 {
+			op.Data.Extra = extra
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		return ErrInvalidEvent
+	}
+	if d.partial == nil {
+		d.partial = newPartialData(d.fields, d.version)
+	}
+	v := reflect.New(d.partial.typ)
+	if err := json.Unmarshal([]byte(value), v.Interface()); err != nil {
+		return err
+	}
+	op.Data = d.partial.data(v.Elem())
+	return nil
+}
+
+// partialData is a struct type declaring only the requested data fields, so the
+// JSON decoder skips the other fields without decoding their values.
+type partialData struct {
+	typ reflect.Type
+	// names are the JSON names of the fields of typ, by field index
+	names []string
+}
+
+// newPartialData builds the struct type holding the given fields. The fields
+// other than those of the first schema version are kept raw, and only if version
+// is 2 or above.
+func newPartialData(fields map[string]bool, version int) *partialData {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	p := &partialData{}
+	sfs := make([]reflect.StructField, 0, len(names))
+	for _, name := range names {
+		var typ reflect.Type
+		switch name {
+		case "id", "type", "ref":
+			typ = reflect.TypeOf("")
+		case "timestamp":
+			typ = reflect.TypeOf(time.Time{})
+		case "parents":
+			typ = reflect.TypeOf([]string(nil))
+		default:
+			if version < 2 {
+				continue
+			}
+			typ = reflect.TypeOf(json.RawMessage(nil))
+		}
+		sfs = append(sfs, reflect.StructField{
+			Name: "F" + strconv.Itoa(len(sfs)),
+			Type: typ,
+			Tag:  reflect.StructTag("json:" + strconv.Quote(name)),
+		})
+		p.names = append(p.names, name)
+	}
+	p.typ = reflect.StructOf(sfs)
+	return p
+}
+
+// data returns the operation data holding the fields decoded in v, a value of
+// the partial type.
+func (p *partialData) data(v reflect.Value) *OperationData {
+	data := &OperationData{}
+	for i, name := range p.names {
+		f := v.Field(i)
+		switch name {
+		case "id":
+			data.ID = f.String()
+		case "type":
+			data.Type = f.String()
+		case "ref":
+			data.Ref = f.String()
+		case "timestamp":
+			data.Timestamp = f.Interface().(time.Time)
+		case "parents":
+			data.Parents = f.Interface().([]string)
+		default:
+			raw := f.Interface().(json.RawMessage)
+			if raw == nil {
+				continue
+			}
+			if data.Extra == nil {
+				data.Extra = map[string]json.RawMessage{}
+			}
+			data.Extra[name] = raw
+		}
+	}
+	return data
+}
+
+// readEvent reads the raw lines of the next event up to the blank line ending it.
+// Leading blank lines and comments not followed by any field are discarded.
+func (d *decoder) readEvent() (raw []byte, err error) {
+	started := false
+	for {
+		line, err := d.readLine()
+		raw = append(raw, line...)
+		if err != nil {
+			return raw, ErrConnectionClosed
+		}
+		if line[0] == '\n' || line[0] == '\r' {
+			if started {
+				// Message is complete
+				return raw, nil
+			}
+			raw = raw[:0]
+			continue
+		}
+		if line[0] != ':' {
+			started = true
+		} else if d.onComment != nil {
+			d.onComment()
+		}
+	}
+}
+
+// readLine reads a line terminated by "\r\n", "\n" or "\r" as per the SSE spec.
+// The returned line includes its terminator.
+func (d *decoder) readLine() (line []byte, err error) {
+	for {
+		b, err := d.ReadByte()
+		if err != nil {
+			return line, err
+		}
+		if d.skipLF {
+			d.skipLF = false
+			if b == '\n' {
+				// End of a "\r\n" terminator already returned
+				continue
+			}
+		}
+		line = append(line, b)
+		switch b {
+		case '\n':
+			return line, nil
+		case '\r':
+			if d.Buffered() == 0 {
+				// Do not block waiting for a possible "\n"
+				d.skipLF = true
+				return line, nil
+			}
+			if next, _ := d.Peek(1); next[0] == '\n' {
+				d.ReadByte()
+				line = append(line, '\n')
+			}
+			return line, nil
+		}
+	}
+}
+
+// parseField splits a SSE line into its field name and value.
+//
+// As per the SSE spec, if the line contains a colon, the field name is what comes
+// before the first colon and the value what comes after it, with a single leading
+// space removed if any. If the line doesn't contain a colon, the whole line is the
+// field name and the value is the empty string. Trailing spaces are not part of the
+// field name.
+func parseField(line string) (field, value string) {
+	if i := strings.IndexByte(line, ':'); i != -1 {
+		field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+	} else {
+		field = line
+	}
+	field = strings.TrimRight(field, " ")
+	return
+}
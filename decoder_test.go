@@ -0,0 +1,213 @@
+package oplogc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		line  string
+		field string
+		value string
+	}{
+		{"id: 123", "id", "123"},
+		{"id:123", "id", "123"},
+		{"id:  123", "id", " 123"},
+		{"id", "id", ""},
+		{"id:", "id", ""},
+		{"data ", "data", ""},
+		{"data : {}", "data", "{}"},
+		{"data: a:b", "data", "a:b"},
+	}
+	for _, tt := range tests {
+		field, value := parseField(tt.line)
+		if field != tt.field || value != tt.value {
+			t.Errorf("parseField(%q) = (%q, %q), want (%q, %q)", tt.line, field, value, tt.field, tt.value)
+		}
+	}
+}
+
+func TestDecoderIDWithNoValue(t *testing.T) {
+	stream := "id: 1\nevent: insert\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n" +
+		"id\nevent: update\ndata: {\"id\":\"b\",\"type\":\"video\"}\n\n"
+	d := newDecoder(strings.NewReader(stream))
+	op := Operation{}
+
+	if err := d.next(&op); err != nil {
+		t.Fatalf("next() error: %v", err)
+	}
+	if op.ID != "1" || op.Event != "insert" || op.Data.ID != "a" {
+		t.Errorf("unexpected first operation: %+v", op)
+	}
+
+	if err := d.next(&op); err != nil {
+		t.Fatalf("next() error: %v", err)
+	}
+	if op.ID != "" {
+		t.Errorf("id not reset by id field with no value: got %q", op.ID)
+	}
+	if op.Event != "update" || op.Data.ID != "b" {
+		t.Errorf("unexpected second operation: %+v", op)
+	}
+}
+
+func TestDecoderBareFields(t *testing.T) {
+	stream := "id: 1\nevent : reset\ndata\n\n"
+	d := newDecoder(strings.NewReader(stream))
+	op := Operation{}
+
+	if err := d.next(&op); err != nil {
+		t.Fatalf("next() error: %v", err)
+	}
+	if op.Event != "reset" {
+		t.Errorf("event = %q, want %q", op.Event, "reset")
+	}
+	if op.Data != nil {
+		t.Errorf("data = %+v, want nil", op.Data)
+	}
+}
+
+func TestDecoderLineEndings(t *testing.T) {
+	for name, eol := range map[string]string{"CRLF": "\r\n", "CR": "\r", "LF": "\n"} {
+		stream := "id: 1" + eol + "event: insert" + eol + "data: {\"id\":\"a\",\"type\":\"video\"}" + eol + eol
+		d := newDecoder(strings.NewReader(stream))
+		op := Operation{}
+
+		if err := d.next(&op); err != nil {
+			t.Errorf("%s: next() error: %v", name, err)
+			continue
+		}
+		if op.ID != "1" || op.Event != "insert" || op.Data.ID != "a" {
+			t.Errorf("%s: unexpected operation: %+v", name, op)
+		}
+	}
+}
+
+func TestDecoderDataFields(t *testing.T) {
+	stream := "id: 1\nevent: insert\ndata: {\"timestamp\":\"2015-01-01T00:00:00Z\",\"id\":\"a\",\"ref\":\"http://x\",\"type\":\"video\",\"parents\":[\"user/1\"]}\n\n"
+	d := newDecoder(strings.NewReader(stream))
+	d.fields = map[string]bool{"id": true, "type": true}
+	op := Operation{}
+
+	if err := d.next(&op); err != nil {
+		t.Fatalf("next() error: %v", err)
+	}
+	if op.Data.ID != "a" || op.Data.Type != "video" {
+		t.Errorf("requested fields not decoded: %+v", op.Data)
+	}
+	if op.Data.Ref != "" || op.Data.Parents != nil || !op.Data.Timestamp.IsZero() {
+		t.Errorf("unrequested fields decoded: %+v", op.Data)
+	}
+}
+
+func TestDecoderMissingID(t *testing.T) {
+	stream := "id: 1\nevent: insert\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n" +
+		"event: live\n\n" +
+		"id: 2\nevent: update\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n" +
+		"event: delete\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n"
+	d := newDecoder(strings.NewReader(stream))
+	op := Operation{}
+
+	for _, want := range []struct{ id, event string }{
+		{"1", "insert"},
+		{"", "live"},
+		{"2", "update"},
+		{"", "delete"},
+	} {
+		if err := d.next(&op); err != nil {
+			t.Fatalf("next() error: %v", err)
+		}
+		if op.ID != want.id || op.Event != want.event {
+			t.Errorf("got %s #%q, want %s #%q", op.Event, op.ID, want.event, want.id)
+		}
+	}
+}
+
+func TestDecoderSchemaVersion(t *testing.T) {
+	stream := "id: 1\nevent: insert\ndata: {\"id\":\"a\",\"type\":\"video\",\"owner\":\"x\",\"tags\":[\"t\"]}\n\n"
+	for _, fields := range []map[string]bool{nil, {"id": true, "owner": true}} {
+		d := newDecoder(strings.NewReader(stream))
+		d.version = 2
+		d.fields = fields
+		op := Operation{}
+
+		if err := d.next(&op); err != nil {
+			t.Fatalf("next() error: %v", err)
+		}
+		if op.Data.ID != "a" || string(op.Data.Extra["owner"]) != `"x"` {
+			t.Errorf("fields %v: unexpected data %+v", fields, op.Data)
+		}
+		if _, found := op.Data.Extra["id"]; found {
+			t.Errorf("fields %v: schema version 1 field in Extra", fields)
+		}
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		stream string
+		err    error
+	}{
+		{"id: 1\nevent: insert\ndata: {\"id\":\n\n", ErrInvalidEvent},
+		{"id: 1\nevent: insert\n\n", ErrInvalidEvent},
+		{"id: 1\ndata: {}\n\n", ErrIncompleteEvent},
+		{"id: 1\nevent: insert\n", ErrConnectionClosed},
+		{"", ErrConnectionClosed},
+	}
+	for _, tt := range tests {
+		d := newDecoder(strings.NewReader(tt.stream))
+		if err := d.next(&Operation{}); err != tt.err {
+			t.Errorf("next() on %q error = %v, want %v", tt.stream, err, tt.err)
+		}
+	}
+}
+
+func TestDecoderControlData(t *testing.T) {
+	stream := "id: 1\nevent: live\ndata: {\"count\":42}\n\n"
+	d := newDecoder(strings.NewReader(stream))
+	op := Operation{}
+
+	if err := d.next(&op); err != nil {
+		t.Fatalf("next() error: %v", err)
+	}
+	if op.Data == nil || string(op.Data.Extra["count"]) != "42" {
+		t.Errorf("live event data not decoded: %+v", op.Data)
+	}
+}
+
+func TestDecoderSize(t *testing.T) {
+	event := "id: 1\nevent: insert\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n"
+	d := newDecoder(strings.NewReader(event))
+	op := Operation{}
+
+	if err := d.next(&op); err != nil {
+		t.Fatalf("next() error: %v", err)
+	}
+	if op.Size() != len(event) {
+		t.Errorf("Size() = %d, want %d", op.Size(), len(event))
+	}
+}
+
+func benchmarkDecodeData(b *testing.B, fields map[string]bool) {
+	data := `{"timestamp":"2015-01-01T00:00:00Z","id":"a","ref":"http://api.example.com/video/a","type":"video","parents":["user/1","channel/news","playlist/x1"]}`
+	d := newDecoder(strings.NewReader(""))
+	d.fields = fields
+	op := Operation{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		op.Data = nil
+		if err := d.decodeData(data, &op); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeDataAllFields(b *testing.B) {
+	benchmarkDecodeData(b, nil)
+}
+
+func BenchmarkDecodeDataSelected(b *testing.B) {
+	benchmarkDecodeData(b, map[string]bool{"id": true, "type": true})
+}
@@ -0,0 +1,86 @@
+package oplogc
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Decompressor returns a reader decoding a stream compressed with a given
+// content encoding.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// GzipDecompressor decodes the gzip content encoding. It is always available
+// when the ContentEncodings option is set.
+func GzipDecompressor(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// Bzip2Decompressor decodes the bzip2 content encoding.
+func Bzip2Decompressor(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bzip2.NewReader(r)), nil
+}
+
+// decompressors returns the decompressors by content encoding name, with gzip
+// built in
+func (c *Consumer) decompressors() map[string]Decompressor {
+	decs := map[string]Decompressor{"gzip": GzipDecompressor}
+	for name, dec := range c.options.ContentEncodings {
+		decs[strings.ToLower(name)] = dec
+	}
+	return decs
+}
+
+// acceptEncoding returns the value of the Accept-Encoding header advertising the
+// supported content encodings
+func (c *Consumer) acceptEncoding() string {
+	names := []string{}
+	for name := range c.decompressors() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// decodeBody wraps the response body to decode the given content encoding. An
+// error is returned if the encoding is not supported.
+func (c *Consumer) decodeBody(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "" || encoding == "identity" {
+		return body, nil
+	}
+	dec, found := c.decompressors()[encoding]
+	if !found {
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+	return &decodedBody{body: body, newReader: dec}, nil
+}
+
+// decodedBody is a response body decoded by a Decompressor. The decompressor is
+// created on the first read so the connection doesn't block until the oplog sends
+// its first bytes.
+type decodedBody struct {
+	body      io.ReadCloser
+	newReader Decompressor
+	r         io.ReadCloser
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.r == nil {
+		r, err := b.newReader(b.body)
+		if err != nil {
+			return 0, err
+		}
+		b.r = r
+	}
+	return b.r.Read(p)
+}
+
+// Close closes the underlying body, which also unblocks any pending read.
+func (b *decodedBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,87 @@
+package oplogc_test
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dailymotion/oplogc"
+)
+
+// flushWriter is a compressing writer flushed to stream what was written so far
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// newEncodedServer returns a server streaming an operation compressed by the
+// given writer with the given content encoding, reporting the Accept-Encoding
+// headers received
+func newEncodedServer(encoding string, compress func(io.Writer) flushWriter) (*httptest.Server, <-chan string) {
+	accepted := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case accepted <- r.Header.Get("Accept-Encoding"):
+		default:
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Encoding", encoding)
+		cw := compress(w)
+		fmt.Fprint(cw, "id: 1\nevent: insert\ndata: {\"id\":\"a\",\"type\":\"video\"}\n\n")
+		cw.Flush()
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	return s, accepted
+}
+
+func testContentEncoding(t *testing.T, s *httptest.Server, accepted <-chan string, options oplogc.Options, encoding string) {
+	t.Helper()
+	c, err := oplogc.Subscribe(s.URL, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, errs, done := c.Start()
+	defer func() {
+		c.Stop()
+		<-done
+	}()
+
+	op := nextOperation(t, ops, errs)
+	if op.ID != "1" || op.Data.Type != "video" {
+		t.Fatalf("got operation %s %+v, want 1 on a video", op.ID, op.Data)
+	}
+	if header := <-accepted; !strings.Contains(header, encoding) {
+		t.Errorf("Accept-Encoding %q doesn't advertise %s", header, encoding)
+	}
+}
+
+func TestConsumerGzipEncoding(t *testing.T) {
+	s, accepted := newEncodedServer("gzip", func(w io.Writer) flushWriter {
+		return gzip.NewWriter(w)
+	})
+	defer s.Close()
+
+	options := oplogc.Options{ContentEncodings: map[string]oplogc.Decompressor{}}
+	testContentEncoding(t, s, accepted, options, "gzip")
+}
+
+func TestConsumerCustomEncoding(t *testing.T) {
+	s, accepted := newEncodedServer("x-deflate", func(w io.Writer) flushWriter {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	})
+	defer s.Close()
+
+	options := oplogc.Options{ContentEncodings: map[string]oplogc.Decompressor{
+		"x-deflate": func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	}}
+	testContentEncoding(t, s, accepted, options, "x-deflate")
+}
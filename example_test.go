@@ -1,6 +1,7 @@
 package oplogc_test
 
 import (
+	"errors"
 	"log"
 
 	"github.com/dailymotion/oplogc"
@@ -8,7 +9,10 @@ import (
 
 func Example() {
 	myOplogURL := "http://oplog.mydomain.com"
-	c := oplogc.Subscribe(myOplogURL, oplogc.Options{})
+	c, err := oplogc.Subscribe(myOplogURL, oplogc.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	ops, errs, done := c.Start()
 
@@ -31,13 +35,13 @@ func Example() {
 			// Ack the fact you handled the operation
 			op.Done()
 		case err := <-errs:
-			switch err {
-			case oplogc.ErrAccessDenied, oplogc.ErrWritingState:
+			switch {
+			case err == oplogc.ErrAccessDenied, err == oplogc.ErrWritingState:
 				c.Stop()
 				log.Fatal(err)
-			case oplogc.ErrResumeFailed:
-				log.Print("Resume failed, forcing full replication")
-				c.SetLastID("0")
+			case errors.Is(err, oplogc.ErrResumeFailed), err == oplogc.ErrFilterChanged:
+				log.Printf("%v: forcing full replication", err)
+				c.FullReplication()
 			default:
 				log.Print(err)
 			}
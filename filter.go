@@ -0,0 +1,157 @@
+package oplogc
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidFilter is returned by Subscribe when a filter value is invalid.
+var ErrInvalidFilter = errors.New("invalid filter value")
+
+// ErrFilterMatchesNothing is sent as a warning when operations are received but
+// all of them have been dropped by the filter for FilterWarningDelay, which may
+// be the sign of a misconfigured filter.
+var ErrFilterMatchesNothing = errors.New("no operation matched the filter")
+
+// cleanFilterValues returns the given filter values without the empty ones. An
+// error wrapping ErrInvalidFilter is returned if a value contains a comma.
+func cleanFilterValues(values []string) ([]string, error) {
+	var cleaned []string
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if strings.Contains(v, ",") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFilter, v)
+		}
+		cleaned = append(cleaned, v)
+	}
+	return cleaned, nil
+}
+
+// match returns true if the operation matches the filter. Reset and live events
+// always match.
+func (f Filter) match(op Operation) bool {
+	if op.Data == nil || op.Kind().IsControl() {
+		return true
+	}
+	if len(f.Types) > 0 && !contains(f.Types, op.Data.Type) {
+		return false
+	}
+	if len(f.Parents) > 0 {
+		found := false
+		for _, p := range op.Data.Parents {
+			if matchParent(f.Parents, p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// hash returns a digest of the filter, independent of the order of its values
+func (f Filter) hash() string {
+	types := append([]string(nil), f.Types...)
+	parents := append([]string(nil), f.Parents...)
+	sort.Strings(types)
+	sort.Strings(parents)
+	h := sha1.New()
+	fmt.Fprintf(h, "types=%s\nparents=%s\n", strings.Join(types, ","), strings.Join(parents, ","))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isEmpty returns true if the filter doesn't filter anything
+func (f Filter) isEmpty() bool {
+	return len(f.Types) == 0 && len(f.Parents) == 0
+}
+
+// splitFilter splits the filter between the part supported by the oplog server,
+// as advertised by the given capabilities header value, and the part which must
+// be applied by the consumer. If the oplog didn't advertise its capabilities, it
+// is assumed to support the whole filter.
+func splitFilter(f Filter, capabilities string) (server, client Filter) {
+	if hasWildcard(f.Parents) {
+		// Wildcards are only supported by the consumer
+		server, client = splitFilter(Filter{Types: f.Types}, capabilities)
+		client.Parents = f.Parents
+		return
+	}
+	if capabilities == "" {
+		return f, Filter{}
+	}
+	supported := strings.Split(capabilities, ",")
+	for i := range supported {
+		supported[i] = strings.TrimSpace(supported[i])
+	}
+	if contains(supported, "types") {
+		server.Types = f.Types
+	} else {
+		client.Types = f.Types
+	}
+	if contains(supported, "parents") {
+		server.Parents = f.Parents
+	} else {
+		client.Parents = f.Parents
+	}
+	return
+}
+
+// hasWildcard returns true if any of the values contains a * wildcard
+func hasWildcard(values []string) bool {
+	for _, v := range values {
+		if strings.Contains(v, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchParent returns true if the parent matches any of the patterns
+func matchParent(patterns []string, parent string) bool {
+	for _, p := range patterns {
+		if p == parent || (strings.Contains(p, "*") && matchGlob(p, parent)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob returns true if s matches the pattern, where * matches any sequence
+// of characters, including none
+func matchGlob(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := len(parts) - 1
+	if last == 0 {
+		return s == ""
+	}
+	for _, part := range parts[1:last] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[last])
+}
+
+// contains returns true if the value is in the list
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package oplogc
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"video/*", "video/x1", true},
+		{"video/*", "user/x1", false},
+		{"*/123", "video/123", true},
+		{"*/123", "video/1234", false},
+		{"user/*/b", "user/a/b", true},
+		{"a*b*b", "ab", false},
+		{"*", "", true},
+		{"video/x1", "video/x1", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSplitFilterWildcard(t *testing.T) {
+	f := Filter{Types: []string{"video"}, Parents: []string{"user/u1", "playlist/*"}}
+	server, client := splitFilter(f, "")
+	if len(server.Types) != 1 || len(server.Parents) != 0 || len(client.Types) != 0 || len(client.Parents) != 2 {
+		t.Errorf("splitFilter() = %+v, %+v, want the parents applied by the consumer only", server, client)
+	}
+}
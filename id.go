@@ -0,0 +1,98 @@
+package oplogc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IDInfo holds the information embedded in an event id.
+type IDInfo struct {
+	// Time is the time embedded in the id, zero if the id doesn't embed a time
+	Time time.Time
+	// Fields holds any other structured component of the id, like a shard number
+	Fields map[string]string
+}
+
+// IDParser extracts the information embedded in an event id.
+type IDParser func(id string) (IDInfo, error)
+
+// ErrInvalidID is returned by ParseID when the id is not a valid oplog id.
+var ErrInvalidID = errors.New("invalid event id")
+
+// ParseID is the default IDParser. It understands the ids generated by the oplog:
+// either a millisecond timestamp or a MongoDB ObjectId (24 hex chars) for which the
+// first 4 bytes are a timestamp in seconds.
+func ParseID(id string) (IDInfo, error) {
+	if t, ok := idTime(id); ok {
+		return IDInfo{Time: t}, nil
+	}
+	return IDInfo{}, ErrInvalidID
+}
+
+// idTime extracts the time embedded in an oplog event id.
+//
+// The returned bool is false if the id doesn't embed a time.
+func idTime(id string) (time.Time, bool) {
+	switch len(id) {
+	case 24:
+		sec, err := strconv.ParseUint(id[:8], 16, 32)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(int64(sec), 0), true
+	case 0:
+		return time.Time{}, false
+	default:
+		if len(id) > 13 {
+			return time.Time{}, false
+		}
+		ms, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || ms == 0 {
+			return time.Time{}, false
+		}
+		return time.Unix(0, ms*int64(time.Millisecond)), true
+	}
+}
+
+// CompareIDs compares two oplog event ids following the oplog ordering. It
+// understands both forms of ids generated by the oplog: ids made of digits only
+// are millisecond timestamps compared numerically, other ids like ObjectIds (24
+// hex chars) are compared lexicographically. It returns -1, 0 or 1 if a is
+// respectively before, equal to or after b.
+func CompareIDs(a, b string) int {
+	if isDigits(a) && isDigits(b) {
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// NextSequentialID returns the id following the given id for oplogs generating
+// strictly sequential decimal ids, or an empty string if the id is not decimal.
+func NextSequentialID(id string) string {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatUint(n+1, 10)
+}
+
+// isDigits returns true if s is a non empty string of decimal digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package oplogc
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestCompareIDs(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"9", "10", -1},
+		{"1420070400000", "999", 1},
+		{"0012", "12", 0},
+		{"54a4a5f0e4b0a3b1c2d3e4f5", "54a4a5f0e4b0a3b1c2d3e4f6", -1},
+		{"54a4a5f1e4b0a3b1c2d3e4f5", "54a4a5f0e4b0a3b1c2d3e4f5", 1},
+	}
+	for _, tt := range tests {
+		if got := CompareIDs(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareIDs(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckGap(t *testing.T) {
+	c := &Consumer{mu: &sync.RWMutex{}}
+	lastSeen := "1"
+	tests := []struct {
+		id  string
+		gap bool
+	}{
+		{"2", false},
+		{"4", true},
+		{"3", false}, // delivered again after a resume
+		{"5", false},
+	}
+	for _, tt := range tests {
+		err := c.checkGap(&lastSeen, Operation{ID: tt.id, Event: "insert"})
+		if gap := errors.Is(err, ErrGap); gap != tt.gap {
+			t.Errorf("checkGap(%s) = %v, want gap %v", tt.id, err, tt.gap)
+		}
+	}
+	if lastSeen != "5" {
+		t.Errorf("last seen id %q, want %q", lastSeen, "5")
+	}
+}
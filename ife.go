@@ -1,57 +1,161 @@
 package oplogc
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 type inFlightEvents struct {
-	sync.RWMutex
-	// ids is the list of in flight event IDs
-	ids []string
+	// mu protects the in flight events bookkeeping
+	mu sync.RWMutex
+	// events is the list of in flight events, followed by the events acked out of
+	// order after the oldest in flight event
+	events []inFlightEvent
+	// size is the sum of the sizes of in flight events
+	size int
+	// released is signaled each time an event is pulled
+	released chan struct{}
+}
+
+type inFlightEvent struct {
+	// id is the event id
+	id string
+	// op is the operation of the event
+	op Operation
+	// since is the time when the event has been pushed
+	since time.Time
+	// size is the approximate size in bytes of the event
+	size int
+	// acked is true when the event has been acked while an older one is still in
+	// flight
+	acked bool
 }
 
 // newInFlightEvents contains events ids which have been received but not yet acked
 func newInFlightEvents() *inFlightEvents {
 	return &inFlightEvents{
-		ids: []string{},
+		events:   []inFlightEvent{},
+		released: make(chan struct{}, 1),
 	}
 }
 
 // count returns the number of events in flight.
 func (ife *inFlightEvents) count() int {
-	ife.RLock()
-	defer ife.RUnlock()
-	return len(ife.ids)
+	ife.mu.RLock()
+	defer ife.mu.RUnlock()
+	count := 0
+	for _, e := range ife.events {
+		if !e.acked {
+			count++
+		}
+	}
+	return count
 }
 
-// push adds a new event id to the IFE
-func (ife *inFlightEvents) push(id string) {
-	ife.Lock()
-	defer ife.Unlock()
+// ids returns the ids of the events in flight.
+func (ife *inFlightEvents) ids() []string {
+	ife.mu.RLock()
+	defer ife.mu.RUnlock()
+	ids := make([]string, 0, len(ife.events))
+	for _, e := range ife.events {
+		if !e.acked {
+			ids = append(ids, e.id)
+		}
+	}
+	return ids
+}
 
-	for _, eid := range ife.ids {
-		if eid == id {
-			// do not push the id if already in
+// bytes returns the approximate size in bytes of the events in flight.
+func (ife *inFlightEvents) bytes() int {
+	ife.mu.RLock()
+	defer ife.mu.RUnlock()
+	return ife.size
+}
+
+// push adds a new event to the IFE with its approximate size in bytes. Events
+// without id are not tracked as they can't be resumed from.
+func (ife *inFlightEvents) push(op Operation, size int) {
+	id := op.ID
+	if id == "" {
+		return
+	}
+	ife.mu.Lock()
+	defer ife.mu.Unlock()
+
+	for i, e := range ife.events {
+		if e.id == id {
+			// do not push the id if already in, but track it again if acked
+			if e.acked {
+				ife.events[i] = inFlightEvent{id: id, op: op, since: time.Now(), size: size}
+				ife.size += size
+			}
 			return
 		}
 	}
 
-	ife.ids = append(ife.ids, id)
+	ife.events = append(ife.events, inFlightEvent{id: id, op: op, since: time.Now(), size: size})
+	ife.size += size
 }
 
-// pull pulls the given id from the list and returns the index
-// of the pulled element in the queue. If the element wasn't found
-// the index is set to -1.
-func (ife *inFlightEvents) pull(id string) (index int) {
-	ife.Lock()
-	defer ife.Unlock()
-	index = -1
+// setSize updates the size of an event in flight
+func (ife *inFlightEvents) setSize(id string, size int) {
+	ife.mu.Lock()
+	defer ife.mu.Unlock()
 
-	for i, eid := range ife.ids {
-		if eid == id {
-			index = i
-			ife.ids = append(ife.ids[:i], ife.ids[i+1:]...)
+	for i, e := range ife.events {
+		if e.id == id && !e.acked {
+			ife.size += size - e.size
+			ife.events[i].size = size
+			return
+		}
+	}
+}
+
+// oldest returns the oldest event in flight with the time since when it is in
+// flight. The returned bool is false if no event is in flight.
+func (ife *inFlightEvents) oldest() (op Operation, since time.Time, ok bool) {
+	ife.mu.RLock()
+	defer ife.mu.RUnlock()
+	if len(ife.events) == 0 {
+		return
+	}
+	// The first event is never acked
+	e := ife.events[0]
+	return e.op, e.since, true
+}
+
+// pull acks the given id and returns the id of the most advanced event acked
+// along with all the events before it, if any. Events acked while an older one is
+// still in flight are kept until it is acked, so the returned id never goes past
+// an event not yet acked.
+func (ife *inFlightEvents) pull(id string) (acked string) {
+	ife.mu.Lock()
+	defer ife.mu.Unlock()
+
+	found := false
+	for i, e := range ife.events {
+		if e.id == id && !e.acked {
+			found = true
+			ife.size -= e.size
+			ife.events[i].acked = true
 			break
 		}
 	}
+	if !found {
+		return
+	}
+
+	n := 0
+	for n < len(ife.events) && ife.events[n].acked {
+		acked = ife.events[n].id
+		n++
+	}
+	ife.events = ife.events[n:]
+
+	select {
+	case ife.released <- struct{}{}:
+	default:
+	}
 
 	return
 }
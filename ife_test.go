@@ -0,0 +1,32 @@
+package oplogc
+
+import "testing"
+
+func TestInFlightEventsOutOfOrderAcks(t *testing.T) {
+	ife := newInFlightEvents()
+	for _, id := range []string{"1", "2", "3", "4"} {
+		ife.push(Operation{ID: id}, 10)
+	}
+
+	for _, tt := range []struct {
+		id    string
+		acked string
+		count int
+	}{
+		{"2", "", 3},
+		{"4", "", 2},
+		{"1", "2", 1},
+		{"1", "", 1},
+		{"3", "4", 0},
+	} {
+		if acked := ife.pull(tt.id); acked != tt.acked {
+			t.Errorf("pull(%q) = %q, want %q", tt.id, acked, tt.acked)
+		}
+		if count := ife.count(); count != tt.count {
+			t.Errorf("after pull(%q), count() = %d, want %d", tt.id, count, tt.count)
+		}
+	}
+	if ife.bytes() != 0 {
+		t.Errorf("bytes() = %d, want 0", ife.bytes())
+	}
+}
@@ -0,0 +1,98 @@
+package oplogc
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MultiConsumer merges the operations of several consumers, for instance connected
+// to different oplog shards, into a single channel.
+//
+// Each consumer keeps its own options, and thus its own state file. Operations are
+// tagged with the name of their source and acking them with Done() acks them on the
+// consumer they come from.
+type MultiConsumer struct {
+	consumers map[string]*Consumer
+}
+
+// SourceError is sent on the MultiConsumer errs channel to identify the source
+// of an error.
+type SourceError struct {
+	// Source is the name of the consumer which sent the error
+	Source string
+	// Err is the error sent by the consumer
+	Err error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Source, e.Err)
+}
+
+// Unwrap returns the error sent by the consumer
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// NewMultiConsumer creates a MultiConsumer merging the given consumers indexed by
+// source name.
+func NewMultiConsumer(consumers map[string]*Consumer) *MultiConsumer {
+	return &MultiConsumer{
+		consumers: consumers,
+	}
+}
+
+// Start starts all the consumers and merges their output. See Consumer.Start().
+//
+// Errors are wrapped in a SourceError, use errors.Is to test for a specific error.
+// A message is sent thru the done channel once all the consumers have ended.
+func (m *MultiConsumer) Start() (ops chan Operation, errs chan error, done chan bool) {
+	ops = make(chan Operation)
+	errs = make(chan error)
+	done = make(chan bool)
+
+	wg := sync.WaitGroup{}
+	for name, c := range m.consumers {
+		wg.Add(1)
+		go m.forward(name, c, ops, errs, &wg)
+	}
+
+	go func() {
+		wg.Wait()
+		done <- true
+	}()
+
+	return
+}
+
+// forward starts the given consumer and forwards its output until it has ended
+func (m *MultiConsumer) forward(name string, c *Consumer, ops chan<- Operation, errs chan<- error, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	cops, cerrs, cdone := c.Start()
+	for {
+		select {
+		case op := <-cops:
+			op.Source = name
+			select {
+			case ops <- op:
+			case <-cdone:
+				return
+			}
+		case err := <-cerrs:
+			select {
+			case errs <- &SourceError{Source: name, Err: err}:
+			case <-cdone:
+				return
+			}
+		case <-cdone:
+			return
+		}
+	}
+}
+
+// Stop instructs all the consumers to stop
+func (m *MultiConsumer) Stop() {
+	for _, c := range m.consumers {
+		c.Stop()
+	}
+}
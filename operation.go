@@ -1,6 +1,38 @@
 package oplogc
 
-import "time"
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventKind is the kind of an operation event.
+type EventKind string
+
+const (
+	// EventInsert is sent when an object is created.
+	EventInsert EventKind = "insert"
+	// EventUpdate is sent when an object is updated.
+	EventUpdate EventKind = "update"
+	// EventDelete is sent when an object is deleted.
+	EventDelete EventKind = "delete"
+	// EventReset is sent at the start of a full replication. The consumer should
+	// reset its data store before handling the subsequent operations.
+	EventReset EventKind = "reset"
+	// EventLive is sent when the consumer caught up with the live operations.
+	EventLive EventKind = "live"
+	// EventHeartbeat is a synthetic operation delivered periodically by the
+	// consumer when the HeartbeatInterval option is set. It doesn't come from the
+	// oplog and doesn't affect the state.
+	EventHeartbeat EventKind = "heartbeat"
+)
+
+// IsControl returns true for events not related to an object, i.e. reset, live
+// and heartbeat.
+func (k EventKind) IsControl() bool {
+	return k == EventReset || k == EventLive || k == EventHeartbeat
+}
 
 // Operation represents an OpLog operation
 type Operation struct {
@@ -10,7 +42,29 @@ type Operation struct {
 	Event string
 	// Data holds the operation metadata.
 	Data *OperationData
-	ack  chan<- Operation
+	// Source is the name of the source the operation comes from when delivered
+	// by a MultiConsumer.
+	Source string
+	// Seq is the position of the operation in the sequence of operations delivered
+	// by the consumer, starting at 1. It keeps increasing across reconnects and
+	// is not changed when the operation is retried, so it can be used to detect
+	// out of order processing independently of the oplog id format.
+	Seq uint64
+	// ConnGen is the generation of the connection the operation was received
+	// from, incremented each time the consumer connects to the oplog. Operations
+	// delivered again after a reconnect have a different generation.
+	ConnGen uint64
+	ack     chan<- Operation
+	// retry is the channel to request the operation to be delivered again
+	retry chan<- Operation
+	// retries is the number of times the operation has been delivered again
+	retries int
+	// once ensures the operation is acked or retried only once
+	once *sync.Once
+	// parseID is the parser used by IDInfo, ParseID if nil
+	parseID IDParser
+	// rawSize is the size of the raw event the operation was decoded from
+	rawSize int
 }
 
 // OperationData is the data part of the SSE event for the operation.
@@ -27,11 +81,129 @@ type OperationData struct {
 	// Parents is a list of strings describing the objects related to the object
 	// refered by the operation.
 	Parents []string `json:"parents"`
+	// Extra holds the raw JSON of the fields added by the schema versions after
+	// the first one, by field name. It is only set when the SchemaVersion option
+	// is 2 or above. For control events, like the live event, it holds all the
+	// fields of the data sent by the oplog, like an expected count or checksum.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// IDInfo returns the information embedded in the operation id, like its time,
+// as extracted by the consumer's IDParser option.
+func (o *Operation) IDInfo() (IDInfo, error) {
+	if o.parseID != nil {
+		return o.parseID(o.ID)
+	}
+	return ParseID(o.ID)
+}
+
+// Kind returns the kind of the operation's event.
+func (o *Operation) Kind() EventKind {
+	return EventKind(o.Event)
 }
 
-// Done must be called once the operation has been processed by the consumer
+// Done must be called once the operation has been processed by the consumer.
+// Subsequent calls are no-ops.
 func (o *Operation) Done() {
-	o.ack <- *o
+	if o.once == nil {
+		o.ack <- *o
+		return
+	}
+	o.once.Do(func() {
+		o.ack <- *o
+	})
+}
+
+// Retry requests the operation to be delivered again after a backoff, instead of
+// acking it, when it failed to be processed. Once the MaxRetries option is
+// reached, the operation is acked and reported with an error wrapping
+// ErrRetriesExhausted. Retry must not be called once Done has been called, and
+// vice versa.
+func (o *Operation) Retry() {
+	if o.retry == nil {
+		// Not delivered by a consumer
+		return
+	}
+	if o.once == nil {
+		o.retry <- *o
+		return
+	}
+	o.once.Do(func() {
+		o.retry <- *o
+	})
+}
+
+// Retries returns the number of times the operation has been delivered again
+// following a call to Retry.
+func (o *Operation) Retries() int {
+	return o.retries
+}
+
+// Age returns the time elapsed since the operation happened. It returns 0 for
+// operations with no data and for control events like reset and live.
+func (o *Operation) Age() time.Duration {
+	if o.Data == nil || o.Kind().IsControl() {
+		return 0
+	}
+	return time.Since(o.Data.Timestamp)
+}
+
+// Parent is an object related to the object refered by an operation.
+type Parent struct {
+	// Type is the parent object type.
+	Type string
+	// ID is the parent object id.
+	ID string
+}
+
+// String returns the parent in its type/id form.
+func (p Parent) String() string {
+	return p.Type + "/" + p.ID
+}
+
+// ParsedParents returns the parents of the object refered by the operation split
+// into their type and id. As types never contain slashes, the type is what comes
+// before the first slash and the id the rest, slashes included. It returns nil
+// for operations without data.
+func (o *Operation) ParsedParents() []Parent {
+	if o.Data == nil || len(o.Data.Parents) == 0 {
+		return nil
+	}
+	parents := make([]Parent, 0, len(o.Data.Parents))
+	for _, p := range o.Data.Parents {
+		parent := Parent{Type: p}
+		if i := strings.IndexByte(p, '/'); i != -1 {
+			parent.Type, parent.ID = p[:i], p[i+1:]
+		}
+		parents = append(parents, parent)
+	}
+	return parents
+}
+
+// Size returns the size in bytes of the SSE event the operation was received
+// as, or an estimate computed from its fields if it has not been received from
+// the oplog.
+func (o Operation) Size() int {
+	if o.rawSize > 0 {
+		return o.rawSize
+	}
+	return o.size()
+}
+
+// size returns the approximate size in bytes of the operation
+func (o *Operation) size() int {
+	size := len(o.ID) + len(o.Event)
+	if o.Data != nil {
+		// 24 bytes for the timestamp
+		size += len(o.Data.ID) + len(o.Data.Type) + len(o.Data.Ref) + 24
+		for _, p := range o.Data.Parents {
+			size += len(p)
+		}
+		for k, v := range o.Data.Extra {
+			size += len(k) + len(v)
+		}
+	}
+	return size
 }
 
 // validate validates an operation's syntax
@@ -39,7 +211,7 @@ func (o *Operation) validate() bool {
 	if o.Event == "" {
 		return false
 	}
-	if o.Data == nil && o.Event != "reset" && o.Event != "live" {
+	if o.Data == nil && !o.Kind().IsControl() {
 		return false
 	}
 	return true
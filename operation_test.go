@@ -0,0 +1,17 @@
+package oplogc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsedParents(t *testing.T) {
+	op := Operation{Data: &OperationData{Parents: []string{"user/x1", "playlist/a/b", "channel"}}}
+	want := []Parent{{"user", "x1"}, {"playlist", "a/b"}, {"channel", ""}}
+	if got := op.ParsedParents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsedParents() = %+v, want %+v", got, want)
+	}
+	if got := (&Operation{}).ParsedParents(); got != nil {
+		t.Errorf("ParsedParents() without data = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,168 @@
+// Package oplogtest provides a minimal oplog agent to test oplog consumers.
+//
+// The server streams a script of operations as SSE events, resuming after the
+// operation matching the Last-Event-ID header when found, or from the start for a
+// full replication requested with the "0" id:
+//
+//	s := oplogtest.NewServer(
+//		oplogc.Operation{ID: "1", Event: "insert", Data: &oplogc.OperationData{ID: "a", Type: "video"}},
+//	)
+//	defer s.Close()
+//	c, err := oplogc.Subscribe(s.URL, oplogc.Options{})
+package oplogtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/dailymotion/oplogc"
+)
+
+// Server is an oplog agent serving a script of operations.
+type Server struct {
+	*httptest.Server
+	// Password is the password required from the consumers, none if empty
+	Password string
+
+	mu sync.Mutex
+	// ops is the script of operations to stream
+	ops []oplogc.Operation
+	// lastEventIDs are the Last-Event-ID headers of the received requests
+	lastEventIDs []string
+	// changed is closed and replaced when new operations are pushed
+	changed chan struct{}
+	// disconnect is closed and replaced to close the current streams
+	disconnect chan struct{}
+	// closed is closed when the server is closing
+	closed chan struct{}
+}
+
+// NewServer starts and returns a new oplog agent streaming the given operations.
+// The caller should call Close when finished, to shut it down.
+func NewServer(ops ...oplogc.Operation) *Server {
+	s := &Server{
+		ops:        ops,
+		changed:    make(chan struct{}),
+		disconnect: make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Push appends operations to the script, streaming them to the connected
+// consumers.
+func (s *Server) Push(ops ...oplogc.Operation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops = append(s.ops, ops...)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Disconnect closes the current streams, forcing the consumers to reconnect.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.disconnect)
+	s.disconnect = make(chan struct{})
+}
+
+// LastEventIDs returns the Last-Event-ID headers of the requests received so
+// far, in order. Requests without the header are reported with an empty string.
+func (s *Server) LastEventIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lastEventIDs...)
+}
+
+// Close closes the current streams and shuts down the server.
+func (s *Server) Close() {
+	close(s.closed)
+	s.Server.Close()
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if s.Password != "" {
+		if _, password, _ := r.BasicAuth(); password != s.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	s.mu.Lock()
+	s.lastEventIDs = append(s.lastEventIDs, lastID)
+	disconnect := s.disconnect
+	next := 0
+	if lastID == "0" {
+		// Full replication, the whole script is streamed
+		w.Header().Set("Last-Event-ID", lastID)
+	} else if lastID != "" {
+		for i, op := range s.ops {
+			if op.ID == lastID {
+				// Resume after the requested operation
+				w.Header().Set("Last-Event-ID", lastID)
+				next = i + 1
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for {
+		s.mu.Lock()
+		ops := s.ops[next:]
+		changed := s.changed
+		s.mu.Unlock()
+
+		for _, op := range ops {
+			if err := writeOperation(w, op); err != nil {
+				return
+			}
+			next++
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-changed:
+		case <-disconnect:
+			return
+		case <-s.closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeOperation writes an operation as a SSE event
+func writeOperation(w http.ResponseWriter, op oplogc.Operation) error {
+	if op.ID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", op.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\n", op.Event); err != nil {
+		return err
+	}
+	if op.Data != nil {
+		data, err := json.Marshal(op.Data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n", data); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprint(w, "\n")
+	return err
+}
@@ -0,0 +1,45 @@
+package oplogc
+
+import "sync"
+
+// recentOperations is a ring buffer of the last delivered operations
+type recentOperations struct {
+	sync.Mutex
+	// ops holds the operations, ops[next] being the oldest once full
+	ops []Operation
+	// next is the index where the next operation is written
+	next int
+	// full is true once the buffer wrapped around
+	full bool
+}
+
+// newRecentOperations creates a ring buffer of the given size
+func newRecentOperations(size int) *recentOperations {
+	return &recentOperations{
+		ops: make([]Operation, size),
+	}
+}
+
+// add adds an operation to the buffer, overwriting the oldest one if full
+func (r *recentOperations) add(op Operation) {
+	r.Lock()
+	defer r.Unlock()
+	r.ops[r.next] = op
+	r.next++
+	if r.next == len(r.ops) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// list returns the buffered operations, oldest first
+func (r *recentOperations) list() []Operation {
+	r.Lock()
+	defer r.Unlock()
+	if !r.full {
+		return append([]Operation(nil), r.ops[:r.next]...)
+	}
+	ops := make([]Operation, 0, len(r.ops))
+	ops = append(ops, r.ops[r.next:]...)
+	return append(ops, r.ops[:r.next]...)
+}
@@ -0,0 +1,83 @@
+package oplogc
+
+import (
+	"strings"
+	"sync"
+)
+
+// Rule matches operations on their object type, parents and event. Empty fields
+// match any value.
+type Rule struct {
+	// Type is the object type
+	Type string
+	// ParentPrefix is a prefix of one of the object parents, like "user/" or
+	// "user/u1"
+	ParentPrefix string
+	// Event is the kind of operation, like insert
+	Event string
+}
+
+// match returns true if the operation matches the rule
+func (r Rule) match(op Operation) bool {
+	if r.Event != "" && op.Event != r.Event {
+		return false
+	}
+	if r.Type != "" && op.Data.Type != r.Type {
+		return false
+	}
+	if r.ParentPrefix != "" {
+		for _, p := range op.Data.Parents {
+			if strings.HasPrefix(p, r.ParentPrefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Ruleset is a set of rules applied by the consumer on the received operations,
+// in addition to the Filter. An operation matching any of the rules is
+// delivered, the others are acked without being delivered. A ruleset without any
+// rule matches no operation. Reset and live events always match.
+//
+// Rules can be added while the consumer is running, the ruleset being safe for
+// concurrent use.
+type Ruleset struct {
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+// NewRuleset returns a ruleset with the given rules.
+func NewRuleset(rules ...Rule) *Ruleset {
+	return &Ruleset{rules: rules}
+}
+
+// Add adds a rule to the ruleset.
+func (rs *Ruleset) Add(rule Rule) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.rules = append(rs.rules, rule)
+}
+
+// Rules returns the rules of the ruleset.
+func (rs *Ruleset) Rules() []Rule {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return append([]Rule(nil), rs.rules...)
+}
+
+// match returns true if the operation matches any of the rules
+func (rs *Ruleset) match(op Operation) bool {
+	if op.Data == nil || op.Kind().IsControl() {
+		return true
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	for _, r := range rs.rules {
+		if r.match(op) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package oplogc
+
+import "testing"
+
+func TestRulesetMatch(t *testing.T) {
+	rs := NewRuleset(Rule{Type: "video", ParentPrefix: "user/u1"})
+	rs.Add(Rule{Event: "delete"})
+	tests := []struct {
+		op   Operation
+		want bool
+	}{
+		{Operation{Event: "insert", Data: &OperationData{Type: "video", Parents: []string{"user/u1"}}}, true},
+		{Operation{Event: "insert", Data: &OperationData{Type: "video", Parents: []string{"user/u2"}}}, false},
+		{Operation{Event: "insert", Data: &OperationData{Type: "playlist", Parents: []string{"user/u1"}}}, false},
+		{Operation{Event: "delete", Data: &OperationData{Type: "playlist"}}, true},
+		{Operation{Event: "reset"}, true},
+	}
+	for _, tt := range tests {
+		if got := rs.match(tt.op); got != tt.want {
+			t.Errorf("match(%s %+v) = %v, want %v", tt.op.Event, tt.op.Data, got, tt.want)
+		}
+	}
+	if NewRuleset().match(tests[0].op) {
+		t.Error("empty ruleset matched an operation")
+	}
+}
@@ -0,0 +1,92 @@
+package oplogc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// StateStore persists the consumer state between executions.
+//
+// The state is an opaque checkpoint holding the last acked event id, the resume
+// token if any, and the optional cursor set by the caller with Consumer.SetCursor.
+type StateStore interface {
+	// Load returns the stored state or nil if no state has been stored yet.
+	Load() ([]byte, error)
+	// Save stores the given state, replacing any previously stored state.
+	Save(state []byte) error
+	// Close releases the resources held by the store. It is called by
+	// Consumer.Close, the store isn't used afterward.
+	Close() error
+}
+
+// FileStateStore is a StateStore persisting the state in a file.
+type FileStateStore struct {
+	// Path of the state file
+	Path string
+}
+
+// Load reads the state from the file. If the file does not exist, nil is returned.
+func (s FileStateStore) Load() ([]byte, error) {
+	state, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return state, err
+}
+
+// Save writes the state to the file.
+func (s FileStateStore) Save(state []byte) error {
+	return ioutil.WriteFile(s.Path, state, 0644)
+}
+
+// Close does nothing as the file is only open while reading or writing it.
+func (s FileStateStore) Close() error {
+	return nil
+}
+
+// stateVersion is the version of the state format written with metadata
+const stateVersion = 1
+
+// state is the consumer state persisted in the state store
+type state struct {
+	// Version is the version of the state format, 0 if saved without metadata
+	Version int `json:"version,omitempty"`
+	// ID is the last acked event id
+	ID string `json:"id"`
+	// Token is the opaque resume token sent by the oplog
+	Token string `json:"token,omitempty"`
+	// Cursor is the caller's checkpoint
+	Cursor []byte `json:"cursor,omitempty"`
+	// SavedAt is the time when the state has been saved, if saved with metadata
+	SavedAt *time.Time `json:"saved_at,omitempty"`
+	// FilterHash is the hash of the filter in use when the state has been saved,
+	// if saved with metadata
+	FilterHash string `json:"filter_hash,omitempty"`
+}
+
+// encode encodes the state. When the state only holds an id, the encoded state
+// is the id itself, otherwise the state is encoded in JSON.
+func (s state) encode() ([]byte, error) {
+	if s.Token == "" && s.Cursor == nil && s.Version == 0 && s.FilterHash == "" {
+		return []byte(s.ID), nil
+	}
+	return json.Marshal(s)
+}
+
+// equal returns true if both states are identical
+func (s state) equal(o state) bool {
+	return s.ID == o.ID && s.Token == o.Token && bytes.Equal(s.Cursor, o.Cursor)
+}
+
+// decodeState decodes a state encoded by state.encode.
+func decodeState(b []byte) (s state, err error) {
+	if len(b) > 0 && b[0] == '{' {
+		err = json.Unmarshal(b, &s)
+		return
+	}
+	s.ID = string(b)
+	return
+}
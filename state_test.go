@@ -0,0 +1,41 @@
+package oplogc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateFormats(t *testing.T) {
+	now := time.Now().UTC()
+	for _, st := range []state{
+		{ID: "123"},
+		{ID: "123", Token: "abc", Cursor: []byte("x")},
+		{Version: stateVersion, ID: "123", SavedAt: &now, FilterHash: Filter{Types: []string{"video"}}.hash()},
+	} {
+		b, err := st.encode()
+		if err != nil {
+			t.Fatalf("encode(%+v) error: %v", st, err)
+		}
+		decoded, err := decodeState(b)
+		if err != nil {
+			t.Fatalf("decodeState(%q) error: %v", b, err)
+		}
+		if !decoded.equal(st) || decoded.Version != st.Version || decoded.FilterHash != st.FilterHash {
+			t.Errorf("decodeState(%q) = %+v, want %+v", b, decoded, st)
+		}
+	}
+	if b, _ := (state{ID: "123"}).encode(); string(b) != "123" {
+		t.Errorf("state without metadata encoded as %q, want the plain id", b)
+	}
+}
+
+func TestFilterHashOrder(t *testing.T) {
+	a := Filter{Types: []string{"video", "user"}, Parents: []string{"user/1"}}
+	b := Filter{Types: []string{"user", "video"}, Parents: []string{"user/1"}}
+	if a.hash() != b.hash() {
+		t.Error("filter hash depends on the order of the values")
+	}
+	if a.hash() == (Filter{Types: []string{"video"}}).hash() {
+		t.Error("different filters have the same hash")
+	}
+}
@@ -0,0 +1,77 @@
+package oplogc
+
+import "time"
+
+// ConsumerStatus is a snapshot of the consumer status.
+type ConsumerStatus struct {
+	// Connected is true while the consumer is connected to the oplog.
+	Connected bool `json:"connected"`
+	// LastID is the most advanced acked event id.
+	LastID string `json:"last_id"`
+	// InFlight is the number of operations sent to the consumer but not yet acked.
+	InFlight int `json:"in_flight"`
+	// LastEventTime is the time when the last received operation happened.
+	LastEventTime time.Time `json:"last_event_time"`
+	// ReconnectCount is the number of times the consumer reconnected to the oplog.
+	ReconnectCount int `json:"reconnect_count"`
+	// IsLive is true once the consumer caught up with the live operations.
+	IsLive bool `json:"is_live"`
+	// HeartbeatCount is the number of heartbeat comments received from the oplog.
+	HeartbeatCount int `json:"heartbeat_count"`
+	// LastHeartbeat is the time when the last heartbeat comment was received. It
+	// allows to tell an idle but alive oplog from a dead connection.
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	// TimeToFirstEvent is the time between the establishment of the current
+	// connection and its first received operation, 0 until it is received. A
+	// growing value is an early sign of a degraded oplog.
+	TimeToFirstEvent time.Duration `json:"time_to_first_event"`
+}
+
+// ReplicationReport summarizes a full replication, from a reset event to the
+// following live event.
+type ReplicationReport struct {
+	// Started is the time when the reset event has been received.
+	Started time.Time `json:"started"`
+	// Ended is the time when the live event has been received, zero while the
+	// replication is in progress.
+	Ended time.Time `json:"ended"`
+	// Counts is the number of operations received by event, like insert, update
+	// and delete, including the operations filtered out by the consumer.
+	Counts map[string]int `json:"counts"`
+}
+
+// Replication returns the report of the current or last full replication. The
+// returned bool is false if no full replication happened since the consumer
+// started.
+func (c *Consumer) Replication() (ReplicationReport, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.replication.Started.IsZero() {
+		return ReplicationReport{}, false
+	}
+	r := c.replication
+	r.Counts = make(map[string]int, len(c.replication.Counts))
+	for event, count := range c.replication.Counts {
+		r.Counts[event] = count
+	}
+	return r, true
+}
+
+// Status returns a snapshot of the consumer status.
+func (c *Consumer) Status() ConsumerStatus {
+	inFlight := c.ife.count()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return ConsumerStatus{
+		Connected:        c.connected,
+		LastID:           c.lastID,
+		InFlight:         inFlight,
+		LastEventTime:    c.lastEventTime,
+		ReconnectCount:   c.reconnects,
+		IsLive:           c.live,
+		HeartbeatCount:   c.heartbeats,
+		LastHeartbeat:    c.lastHeartbeat,
+		TimeToFirstEvent: c.firstEvent,
+	}
+}
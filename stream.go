@@ -0,0 +1,89 @@
+package oplogc
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Stream gives access to the output of a started consumer along with flow control.
+type Stream struct {
+	// Ops is the channel the operations are sent thru, see Consumer.Start()
+	Ops <-chan Operation
+	// Errs is the channel the errors are sent thru
+	Errs <-chan error
+	// Done receives a message once the process loop has ended
+	Done <-chan bool
+
+	c *Consumer
+}
+
+// Stream starts the process loop like Start and returns its output as a Stream.
+func (c *Consumer) Stream() *Stream {
+	ops, errs, done := c.Start()
+	return &Stream{
+		Ops:  ops,
+		Errs: errs,
+		Done: done,
+		c:    c,
+	}
+}
+
+// Backlog returns the number of operations delivered but not yet acked.
+func (s *Stream) Backlog() int {
+	return s.c.ife.count()
+}
+
+// Block pauses the delivery of operations when blocked is true, and resumes it
+// when false. While paused, the oplog stream is no longer read but the connection
+// is kept open.
+func (s *Stream) Block(blocked bool) {
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	if blocked && s.c.unblocked == nil {
+		s.c.unblocked = make(chan struct{})
+	} else if !blocked && s.c.unblocked != nil {
+		close(s.c.unblocked)
+		s.c.unblocked = nil
+	}
+}
+
+// jsonOperation is the JSON representation of an operation written by StreamJSON
+type jsonOperation struct {
+	ID     string         `json:"id,omitempty"`
+	Event  string         `json:"event"`
+	Data   *OperationData `json:"data,omitempty"`
+	Source string         `json:"source,omitempty"`
+}
+
+// StreamJSON starts the process loop and writes each operation to w as a
+// newline-delimited JSON object, like {"id":"...","event":"insert","data":{...}},
+// acking it once written. It blocks until the process loop ends, returning nil,
+// or until an error stops it. Writing to w, ErrAccessDenied and ErrWritingState
+// errors stop the process loop and are returned, other errors are ignored. It
+// returns ErrAlreadyStarted if the process loop is already running.
+func (c *Consumer) StreamJSON(w io.Writer) error {
+	ops, errs, done, err := c.TryStart()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case op := <-ops:
+			if err := enc.Encode(jsonOperation{ID: op.ID, Event: op.Event, Data: op.Data, Source: op.Source}); err != nil {
+				c.Stop()
+				<-done
+				return err
+			}
+			op.Done()
+		case err := <-errs:
+			if err == ErrAccessDenied || err == ErrWritingState {
+				c.Stop()
+				<-done
+				return err
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
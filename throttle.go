@@ -0,0 +1,27 @@
+package oplogc
+
+import "time"
+
+// pacer spaces out operations to deliver at most a given number of operations
+// per second.
+type pacer struct {
+	// next is the time from which the next operation can be delivered
+	next time.Time
+}
+
+// delay returns the time to wait before delivering an operation at the given
+// rate in operations per second, and reserves its slot. There is no limit if rate
+// is 0. Unused slots are not accumulated, so the rate is never exceeded after an
+// idle period.
+func (p *pacer) delay(now time.Time, rate int) time.Duration {
+	if rate <= 0 {
+		p.next = time.Time{}
+		return 0
+	}
+	if p.next.Before(now) {
+		p.next = now
+	}
+	d := p.next.Sub(now)
+	p.next = p.next.Add(time.Second / time.Duration(rate))
+	return d
+}
@@ -0,0 +1,27 @@
+package oplogc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacerDelay(t *testing.T) {
+	var p pacer
+	now := time.Now()
+	for i, want := range []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if d := p.delay(now, 10); d != want {
+			t.Errorf("delay #%d = %s, want %s", i, d, want)
+		}
+	}
+	// Slots unused while idle are not accumulated
+	now = now.Add(time.Minute)
+	if d := p.delay(now, 10); d != 0 {
+		t.Errorf("delay after idle = %s, want 0", d)
+	}
+	if d := p.delay(now, 10); d != 100*time.Millisecond {
+		t.Errorf("delay after idle = %s, want 100ms", d)
+	}
+	if d := p.delay(now, 0); d != 0 {
+		t.Errorf("delay without limit = %s, want 0", d)
+	}
+}